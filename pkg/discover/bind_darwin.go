@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package discover
+
+import (
+	"net"
+	"syscall"
+)
+
+// ipBoundIF and ipv6BoundIF are IP_BOUND_IF and IPV6_BOUND_IF, which the syscall
+// package does not export on darwin.
+const (
+	ipBoundIF   = 25
+	ipv6BoundIF = 125
+)
+
+// dialerForInterface returns a *net.Dialer whose sockets are pinned to ifaceName
+// via IP_BOUND_IF (IPV6_BOUND_IF for an IPv6 targetIP), the darwin/BSD equivalent
+// of Linux's SO_BINDTODEVICE. An empty ifaceName, or an interface that can't be
+// resolved, returns a plain dialer that lets the kernel choose, same as before.
+// network is unused here: the option pins the interface by index without needing
+// a network-matched local address (unlike the LocalAddr fallback used on other
+// platforms).
+func dialerForInterface(ifaceName string, targetIP net.IP, _ string) *net.Dialer {
+	if ifaceName == "" {
+		return &net.Dialer{}
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return &net.Dialer{}
+	}
+
+	level, opt := syscall.IPPROTO_IP, ipBoundIF
+	if targetIP != nil && targetIP.To4() == nil {
+		level, opt = syscall.IPPROTO_IPV6, ipv6BoundIF
+	}
+
+	return &net.Dialer{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var ctrlErr error
+			if err := c.Control(func(fd uintptr) {
+				ctrlErr = syscall.SetsockoptInt(int(fd), level, opt, iface.Index)
+			}); err != nil {
+				return err
+			}
+			return ctrlErr
+		},
+	}
+}
@@ -0,0 +1,39 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package discover
+
+import (
+	"net"
+	"syscall"
+)
+
+// dialerForInterface returns a *net.Dialer whose sockets are pinned to ifaceName
+// via SO_BINDTODEVICE, so outbound probes leave via that interface regardless of
+// what the routing table would otherwise pick. An empty ifaceName returns a plain
+// dialer that lets the kernel choose, same as before. targetIP and network are
+// unused here: SO_BINDTODEVICE pins the interface without needing a
+// family/network-matched local address (unlike the LocalAddr fallback used on
+// other platforms).
+func dialerForInterface(ifaceName string, _ net.IP, _ string) *net.Dialer {
+	if ifaceName == "" {
+		return &net.Dialer{}
+	}
+
+	return &net.Dialer{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var ctrlErr error
+			if err := c.Control(func(fd uintptr) {
+				ctrlErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifaceName)
+			}); err != nil {
+				return err
+			}
+			return ctrlErr
+		},
+	}
+}
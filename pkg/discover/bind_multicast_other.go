@@ -0,0 +1,19 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package discover
+
+import "net"
+
+// setMulticastInterface is not implemented on this platform: the standard
+// library exposes no portable way to pin a UDP socket's outbound multicast
+// interface outside of linux/darwin's IP_MULTICAST_IF. Callers treat this as a
+// best-effort step and log a debug message rather than failing the probe.
+func setMulticastInterface(_ net.PacketConn, iface *net.Interface) error {
+	return errMulticastBindUnsupported
+}
@@ -0,0 +1,43 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux || darwin
+
+package discover
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastInterface pins the outbound interface used for IPv4 multicast
+// traffic on conn to iface, via IP_MULTICAST_IF. Without this, every socket
+// sends IPv4 multicast packets via whatever interface the default route picks,
+// regardless of which interface the caller actually bound the socket to.
+func setMulticastInterface(conn net.PacketConn, iface *net.Interface) error {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return errNotUDPConn
+	}
+
+	addr, err := firstIPv4(iface)
+	if err != nil {
+		return err
+	}
+
+	rawConn, err := udpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var ctrlErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		ctrlErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+	}); err != nil {
+		return err
+	}
+	return ctrlErr
+}
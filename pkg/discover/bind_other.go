@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux && !darwin
+
+package discover
+
+import "net"
+
+// dialerForInterface falls back to binding the dialer's LocalAddr to an address
+// on ifaceName whose family matches targetIP, since DialContext fails outright if
+// LocalAddr and the dial target are different address families (e.g. an IPv4
+// LocalAddr dialing an IPv6 target). This doesn't pin outbound routing the way
+// SO_BINDTODEVICE/IP_BOUND_IF do, but it's the best portable option available
+// (Windows exposes no equivalent socket option through the standard library). If
+// targetIP is nil, an IPv4 address is preferred. LocalAddr also has to be built as
+// the type matching network ("udp" for ONVIF unicast WS-Discovery, "tcp"
+// otherwise) - DialContext fails with "mismatched local address type" if a
+// *net.TCPAddr LocalAddr is set while dialing "udp".
+func dialerForInterface(ifaceName string, targetIP net.IP, network string) *net.Dialer {
+	d := &net.Dialer{}
+	if ifaceName == "" {
+		return d
+	}
+
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return d
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return d
+	}
+
+	wantV4 := targetIP == nil || targetIP.To4() != nil
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if (ipNet.IP.To4() != nil) != wantV4 {
+			continue
+		}
+		if network == udp {
+			d.LocalAddr = &net.UDPAddr{IP: ipNet.IP}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: ipNet.IP}
+		}
+		break
+	}
+	return d
+}
@@ -11,27 +11,42 @@ import (
 	"encoding/binary"
 	"github.com/pkg/errors"
 	"math"
+	"math/big"
 	"math/bits"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
 	udp = "udp"
+
+	// maxIPv6HostBits is the largest IPv6 host-portion size (in bits) that AutoDiscover
+	// is willing to sweep address-by-address. A /64 or larger has 2^64 or more host
+	// addresses and would never finish, so subnets beyond this are skipped during the
+	// sweep; WS-Discovery multicast probing is the supported way to find devices on them.
+	maxIPv6HostBits = 24
+
+	// defaultMaxIPv6SweepHosts caps how many addresses of an in-range IPv6 prefix are
+	// actually enumerated when Params.MaxIPv6SweepHosts is left unset.
+	defaultMaxIPv6SweepHosts = 4096
 )
 
 // AutoDiscover probes all addresses in the configured network to attempt to discover any possible
-// devices for a specific protocol
-func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params Params) []DiscoveredDevice {
+// devices for a specific protocol. The returned ProbeStats snapshots this run's probe pool as of
+// the moment AutoDiscover returns. The counters are kept per run rather than behind a package-level
+// Stats() (see the discoveryStats doc comment in ratelimit.go for why), and they are not exposed to
+// callers while the run is still in flight.
+func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params Params) ([]DiscoveredDevice, ProbeStats) {
 	if len(params.Subnets) == 0 {
 		params.Logger.Warnf("Discover was called, but no subnet information has been configured!")
-		return nil
+		return nil, ProbeStats{}
 	}
 
-	ipnets := make([]*net.IPNet, 0, len(params.Subnets))
+	ipnets := make([]subnetSpec, 0, len(params.Subnets))
 	var estimatedProbes int
 	for _, cidr := range params.Subnets {
 		if cidr == "" {
@@ -44,16 +59,40 @@ func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params P
 			params.Logger.Errorf("Unable to parse CIDR %q: %s", cidr, err)
 			continue
 		}
-		if ip == nil || ipnet == nil || ip.To4() == nil {
-			params.Logger.Errorf("Currently only ipv4 subnets are supported. subnet=%q", cidr)
+		if ip == nil || ipnet == nil {
+			params.Logger.Errorf("Unable to parse CIDR %q", cidr)
+			continue
+		}
+
+		iface := params.SubnetInterfaces[cidr]
+		if iface != "" {
+			sanityCheckInterface(params, cidr, ipnet, iface)
+		}
+
+		if ip.To4() != nil {
+			// compute the estimate total amount of network probes we are going to make
+			// this is an estimate because it may be lower due to skipped addresses (existing devices)
+			sz, _ := ipnet.Mask.Size()
+			estimatedProbes += int(computeNetSz(sz))
+			ipnets = append(ipnets, subnetSpec{ipnet: ipnet, iface: iface})
 			continue
 		}
 
-		ipnets = append(ipnets, ipnet)
-		// compute the estimate total amount of network probes we are going to make
-		// this is an estimate because it may be lower due to skipped addresses (existing devices)
-		sz, _ := ipnet.Mask.Size()
-		estimatedProbes += int(computeNetSz(sz))
+		// ipv6: refuse to sweep prefixes so large we'd never finish (e.g. a /64 has
+		// 2^64 host addresses). Smaller, explicitly-routed prefixes are still swept,
+		// capped at MaxIPv6SweepHosts addresses. Use MulticastDiscover to find devices
+		// on large IPv6 prefixes instead of sweeping them.
+		ones, totalBits := ipnet.Mask.Size()
+		hostBitCount := totalBits - ones
+		if hostBitCount > maxIPv6HostBits {
+			params.Logger.Warnf(
+				"Subnet %q is too large to sweep as IPv6 (%d host bits, max %d); use WS-Discovery multicast instead",
+				cidr, hostBitCount, maxIPv6HostBits)
+			continue
+		}
+
+		ipnets = append(ipnets, subnetSpec{ipnet: ipnet, iface: iface})
+		estimatedProbes += int(ipv6SweepCap(hostBitCount, params.MaxIPv6SweepHosts))
 	}
 
 	// if the estimated amount of probes we are going to make is less than
@@ -63,6 +102,10 @@ func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params P
 	if estimatedProbes < asyncLimit {
 		asyncLimit = estimatedProbes
 	}
+	if asyncLimit <= 0 {
+		params.Logger.Warnf("No usable subnets were configured, unable to scan for Onvif cameras.")
+		return nil, ProbeStats{}
+	}
 
 	probeFactor := time.Duration(math.Ceil(float64(estimatedProbes) / float64(asyncLimit)))
 	portCount := len(params.ScanPorts)
@@ -72,15 +115,19 @@ func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params P
 		probeFactor*params.Timeout*time.Duration(portCount),
 		probeFactor*params.Timeout*time.Duration(math.Min(float64(portCount), float64(params.MaxTimeoutsPerHost))))
 
-	ipCh := make(chan uint32, asyncLimit)
+	ipCh := make(chan probeTarget, asyncLimit)
 	resultCh := make(chan []ProbeResult)
 
+	stats := newDiscoveryStats()
 	wParams := workerParams{
-		Params:   params,
-		ipCh:     ipCh,
-		resultCh: resultCh,
-		ctx:      ctx,
-		proto:    proto,
+		Params:      params,
+		ipCh:        ipCh,
+		resultCh:    resultCh,
+		ctx:         ctx,
+		proto:       proto,
+		rateLimiter: newTokenBucket(params.MaxProbesPerSecond),
+		limiters:    newLimiterRegistry(asyncLimit),
+		stats:       stats,
 	}
 
 	// start the workers before adding any ips so they are ready to process
@@ -105,9 +152,9 @@ func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params P
 
 			// wait on each ipGenerator
 			wgIPGenerators.Add(1)
-			go func(inet *net.IPNet) {
+			go func(spec subnetSpec) {
 				defer wgIPGenerators.Done()
-				ipGenerator(ctx, inet, ipCh)
+				ipGenerator(ctx, spec, params.MaxIPv6SweepHosts, ipCh)
 			}(ipnet)
 		}
 
@@ -122,7 +169,8 @@ func AutoDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params P
 	}()
 
 	// this blocks until the resultCh is closed in above go routine
-	return processResultChannel(resultCh, proto, params)
+	devices := processResultChannel(resultCh, proto, params)
+	return devices, stats.Snapshot()
 }
 
 // processResultChannel reads all incoming results until the resultCh is closed.
@@ -149,14 +197,75 @@ func processResultChannel(resultCh chan []ProbeResult, proto ProtocolSpecificDis
 	return devices
 }
 
+// ipv6SweepCap returns the size of the address range (offsets 0 through n-1 from the
+// prefix base) of a hostBits-sized IPv6 prefix that a sweep covers, honoring an
+// explicit cap if one was configured. ipGeneratorV6 skips offset 0, so it sends one
+// address fewer than this.
+func ipv6SweepCap(hostBits int, configuredCap int) uint64 {
+	total := uint64(1) << uint(hostBits)
+	max := uint64(defaultMaxIPv6SweepHosts)
+	if configuredCap > 0 {
+		max = uint64(configuredCap)
+	}
+	if total > max {
+		return max
+	}
+	return total
+}
+
+// subnetSpec pairs a parsed subnet with the (optional) outbound interface probes
+// against it should be bound to, per Params.SubnetInterfaces.
+type subnetSpec struct {
+	ipnet *net.IPNet
+	iface string
+}
+
+// probeTarget is a single address queued for probing, along with the outbound
+// interface (if any) it should be dialed from.
+type probeTarget struct {
+	IP    net.IP
+	Iface string
+}
+
+// sanityCheckInterface logs a warning if iface has no address within ipnet, since
+// probes for that subnet would then leave via whatever interface the kernel's
+// routing table happens to prefer instead of the one the user configured.
+func sanityCheckInterface(params Params, cidr string, ipnet *net.IPNet, ifaceName string) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		params.Logger.Warnf("Configured interface %q for subnet %q was not found: %s", ifaceName, cidr, err.Error())
+		return
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		params.Logger.Warnf("Unable to read addresses for interface %q: %s", ifaceName, err.Error())
+		return
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipnet.Contains(ipNet.IP) {
+			return
+		}
+	}
+	params.Logger.Warnf(
+		"Interface %q has no address within subnet %q; probes for this subnet may leave via the wrong interface",
+		ifaceName, cidr)
+}
+
 // ipGenerator generates all valid IP addresses for a given subnet, and
 // sends them to the ip channel one at a time
-func ipGenerator(ctx context.Context, inet *net.IPNet, ipCh chan<- uint32) {
-	addr := inet.IP.To4()
-	if addr == nil {
+func ipGenerator(ctx context.Context, spec subnetSpec, maxIPv6SweepHosts int, ipCh chan<- probeTarget) {
+	if addr := spec.ipnet.IP.To4(); addr != nil {
+		ipGeneratorV4(ctx, spec, addr, ipCh)
 		return
 	}
+	ipGeneratorV6(ctx, spec, maxIPv6SweepHosts, ipCh)
+}
 
+// ipGeneratorV4 enumerates every usable host address in an IPv4 subnet.
+func ipGeneratorV4(ctx context.Context, spec subnetSpec, addr net.IP, ipCh chan<- probeTarget) {
+	inet := spec.ipnet
 	mask := inet.Mask
 	if len(mask) == net.IPv6len {
 		mask = mask[12:]
@@ -169,7 +278,7 @@ func ipGenerator(ctx context.Context, inet *net.IPNet, ipCh chan<- uint32) {
 	if maskSz <= 1 {
 		return // skip point-to-point connections
 	} else if maskSz >= 31 {
-		ipCh <- binary.BigEndian.Uint32(inet.IP)
+		sendIP(ctx, ipCh, ipFromUint32(binary.BigEndian.Uint32(inet.IP)), spec.iface)
 		return
 	}
 
@@ -180,34 +289,99 @@ func ipGenerator(ctx context.Context, inet *net.IPNet, ipCh chan<- uint32) {
 			continue
 		}
 
-		select {
-		case <-ctx.Done():
-			// bail if we have been cancelled
+		if !sendIP(ctx, ipCh, ipFromUint32(ip), spec.iface) {
+			return
+		}
+	}
+}
+
+// ipGeneratorV6 enumerates up to maxIPv6SweepHosts (or defaultMaxIPv6SweepHosts, if
+// unset) host addresses of an IPv6 subnet. Callers are expected to have already
+// refused prefixes whose host portion is too large to ever finish sweeping; this
+// function only guards against enumerating more than the configured cap.
+func ipGeneratorV6(ctx context.Context, spec subnetSpec, maxIPv6SweepHosts int, ipCh chan<- probeTarget) {
+	inet := spec.ipnet
+	ones, sz := inet.Mask.Size()
+	if sz != 128 {
+		return
+	}
+	hostBitCount := sz - ones
+	if hostBitCount <= 1 {
+		// /127 and /128: too few host addresses for the cap-and-enumerate logic
+		// below to apply. Probe the base address itself, the same way
+		// ipGeneratorV4 handles /31 and /32.
+		sendIP(ctx, ipCh, inet.IP, spec.iface)
+		return
+	}
+
+	count := ipv6SweepCap(hostBitCount, maxIPv6SweepHosts)
+	base := new(big.Int).SetBytes(inet.IP.To16())
+	for i := uint64(1); i < count; i++ {
+		addr := new(big.Int).Add(base, new(big.Int).SetUint64(i))
+		b := addr.Bytes()
+		ip := make(net.IP, net.IPv6len)
+		copy(ip[net.IPv6len-len(b):], b)
+
+		if !sendIP(ctx, ipCh, ip, spec.iface) {
 			return
-		case ipCh <- ip:
 		}
 	}
 }
 
+// sendIP sends ip (and its associated outbound interface, if any) on ipCh,
+// returning false if the context was cancelled first.
+func sendIP(ctx context.Context, ipCh chan<- probeTarget, ip net.IP, iface string) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case ipCh <- probeTarget{IP: ip, Iface: iface}:
+		return true
+	}
+}
+
+func ipFromUint32(a uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, a)
+	return ip
+}
+
 // probe attempts to make a connection to a specific ip and port to determine
 // if an Onvif camera exists at that network address
-func probe(host string, ports []string, params workerParams) ([]ProbeResult, error) {
+func probe(target probeTarget, ports []string, params workerParams) ([]ProbeResult, error) {
+	host := target.IP.String()
+	limiter := params.limiters.get(target.IP)
+	dialer := dialerForInterface(target.Iface, target.IP, params.NetworkProtocol)
+	dialer.Timeout = params.Timeout
+
 	var allDevices []ProbeResult
 	timeoutCount := 0
 	for _, port := range ports {
-		addr := host + ":" + port
-		conn, err := net.DialTimeout(params.NetworkProtocol, addr, params.Timeout)
+		if err := params.rateLimiter.Take(params.ctx); err != nil {
+			return allDevices, err
+		}
+		if err := limiter.Acquire(params.ctx); err != nil {
+			return allDevices, err
+		}
+
+		addr := net.JoinHostPort(host, port)
+		atomic.AddInt64(&params.stats.inFlight, 1)
+		conn, err := dialer.DialContext(params.ctx, params.NetworkProtocol, addr)
+		atomic.AddInt64(&params.stats.inFlight, -1)
+
+		isTimeout := err != nil && strings.Contains(err.Error(), "i/o timeout")
+		limiter.Release(isTimeout)
 
 		if err != nil {
-			if strings.Contains(err.Error(), "i/o timeout") {
+			if isTimeout {
 				timeoutCount++
+				atomic.AddInt64(&params.stats.timeouts, 1)
 			}
 			if errors.Is(err, syscall.EHOSTUNREACH) || (params.MaxTimeoutsPerHost != 0 && timeoutCount >= params.MaxTimeoutsPerHost) {
 				// quit probing this host
 				return nil, err
 			}
 			// otherwise keep trying
-			if !errors.Is(err, syscall.ECONNREFUSED) && !strings.Contains(err.Error(), "i/o timeout") {
+			if !errors.Is(err, syscall.ECONNREFUSED) && !isTimeout {
 				params.Logger.Debugf(err.Error())
 			}
 			continue
@@ -218,41 +392,43 @@ func probe(host string, ports []string, params workerParams) ([]ProbeResult, err
 
 			// on udp, the dial is always successful, so don't print
 			if params.NetworkProtocol != udp {
-				params.Logger.Infof("Connection dialed %s://%s:%s", params.NetworkProtocol, host, port)
+				params.Logger.Infof("Connection dialed %s://%s", params.NetworkProtocol, addr)
 			}
 
 			results, err := params.proto.OnConnectionDialed(host, port, conn, params.Params)
 			if err != nil {
 				params.Logger.Debugf(err.Error())
 			} else if len(results) > 0 {
+				atomic.AddInt64(&params.stats.hits, int64(len(results)))
 				allDevices = append(allDevices, results...)
 			}
+
+			if params.Fingerprint {
+				if fp := fingerprint(target, port, params); fp != nil {
+					allDevices = append(allDevices, *fp)
+				}
+			}
 		}()
 	}
 	return allDevices, nil
 }
 
-// ipWorker pulls uint32s from the ipCh, convert to IPs, filters then ip
-// to determine if a probe is to be made, makes the probe, and sends back successful
-// probes to the resultCh.
+// ipWorker pulls addresses from the ipCh, filters them to determine if a probe is
+// to be made, makes the probe, and sends back successful probes to the resultCh.
 func ipWorker(params workerParams) {
-	ip := net.IP([]byte{0, 0, 0, 0})
-
 	for {
 		select {
 		case <-params.ctx.Done():
 			// stop working if we have been cancelled
 			return
 
-		case a, ok := <-params.ipCh:
+		case target, ok := <-params.ipCh:
 			if !ok {
 				// channel has been closed
 				return
 			}
 
-			binary.BigEndian.PutUint32(ip, a)
-
-			ipStr := ip.String()
+			ipStr := target.IP.String()
 
 			// filter out which ports to actually scan, and skip this host if no ports are returned
 			ports := params.proto.ProbeFilter(ipStr, params.ScanPorts)
@@ -260,7 +436,7 @@ func ipWorker(params workerParams) {
 				continue
 			}
 
-			if info, err := probe(ipStr, ports, params); err == nil && len(info) > 0 {
+			if info, err := probe(target, ports, params); err == nil && len(info) > 0 {
 				params.resultCh <- info
 			}
 		}
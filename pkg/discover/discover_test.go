@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestIpv6SweepCap(t *testing.T) {
+	tests := []struct {
+		name          string
+		hostBits      int
+		configuredCap int
+		want          uint64
+	}{
+		{"small prefix under default cap", 8, 0, 256},
+		{"prefix exactly at default cap", 12, 0, defaultMaxIPv6SweepHosts},
+		{"prefix larger than default cap", 16, 0, defaultMaxIPv6SweepHosts},
+		{"explicit cap smaller than prefix", 16, 100, 100},
+		{"explicit cap larger than prefix", 4, 1000, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ipv6SweepCap(tt.hostBits, tt.configuredCap)
+			if got != tt.want {
+				t.Errorf("ipv6SweepCap(%d, %d) = %d, want %d", tt.hostBits, tt.configuredCap, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIpGeneratorV6(t *testing.T) {
+	t.Run("single address prefix probes the base address", func(t *testing.T) {
+		_, inet, err := net.ParseCIDR("2001:db8::5/128")
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+
+		ch := make(chan probeTarget, 10)
+		ipGeneratorV6(context.Background(), subnetSpec{ipnet: inet}, 0, ch)
+		close(ch)
+
+		var got []string
+		for target := range ch {
+			got = append(got, target.IP.String())
+		}
+		want := []string{"2001:db8::5"}
+		if len(got) != len(want) || got[0] != want[0] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("point-to-point prefix probes the base address", func(t *testing.T) {
+		_, inet, err := net.ParseCIDR("2001:db8::1/127")
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+
+		ch := make(chan probeTarget, 10)
+		ipGeneratorV6(context.Background(), subnetSpec{ipnet: inet}, 0, ch)
+		close(ch)
+
+		var got []string
+		for target := range ch {
+			got = append(got, target.IP.String())
+		}
+		want := []string{"2001:db8::"}
+		if len(got) != len(want) || got[0] != want[0] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("small prefix enumerates hosts excluding the network address", func(t *testing.T) {
+		_, inet, err := net.ParseCIDR("2001:db8::/126")
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+
+		ch := make(chan probeTarget, 10)
+		ipGeneratorV6(context.Background(), subnetSpec{ipnet: inet}, 0, ch)
+		close(ch)
+
+		var got []string
+		for target := range ch {
+			got = append(got, target.IP.String())
+		}
+		want := []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}
+		if len(got) != len(want) {
+			t.Fatalf("got %d addresses %v, want %d: %v", len(got), got, len(want), want)
+		}
+		for i, ip := range want {
+			if got[i] != ip {
+				t.Errorf("address %d = %s, want %s", i, got[i], ip)
+			}
+		}
+	})
+
+	t.Run("explicit cap limits enumeration", func(t *testing.T) {
+		_, inet, err := net.ParseCIDR("2001:db8::/120") // 256 host addresses
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+
+		ch := make(chan probeTarget, 300)
+		ipGeneratorV6(context.Background(), subnetSpec{ipnet: inet}, 10, ch)
+		close(ch)
+
+		// a cap of 10 hosts enumerates addresses 1-9 (the network address itself,
+		// offset 0, is never sent)
+		if len(ch) != 9 {
+			t.Fatalf("expected 9 addresses with a cap of 10, got %d", len(ch))
+		}
+	})
+
+	t.Run("propagates the subnet's configured interface", func(t *testing.T) {
+		_, inet, err := net.ParseCIDR("2001:db8::/126")
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+
+		ch := make(chan probeTarget, 10)
+		ipGeneratorV6(context.Background(), subnetSpec{ipnet: inet, iface: "eth1"}, 0, ch)
+		close(ch)
+
+		for target := range ch {
+			if target.Iface != "eth1" {
+				t.Errorf("target %s has Iface %q, want %q", target.IP, target.Iface, "eth1")
+			}
+		}
+	})
+}
@@ -0,0 +1,196 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ports that get a protocol-specific fingerprinting pass instead of the default
+// unicast WS-Discovery probe.
+var (
+	httpFingerprintPorts = map[string]bool{"80": true, "8080": true, "8000": true}
+	tlsFingerprintPorts  = map[string]bool{"443": true, "8443": true}
+)
+
+// fingerprint performs a bounded, best-effort identification of whatever is
+// listening on target.IP:port: an HTTP banner grab, a TLS handshake (to read the
+// certificate's SANs), or a unicast WS-Discovery Probe for suspected ONVIF ports.
+// It returns nil if nothing useful could be determined within params.Timeout.
+//
+// The dial is bound to target.Iface (same as the initial probe) and shares that
+// subnet's rate limiter and AIMD concurrency slot, so enabling Fingerprint
+// doesn't double a host's outbound connections with no rate control.
+func fingerprint(target probeTarget, port string, wp workerParams) *ProbeResult {
+	switch {
+	case httpFingerprintPorts[port]:
+		return fingerprintHTTP(target, port, wp)
+	case tlsFingerprintPorts[port]:
+		return fingerprintTLS(target, port, wp)
+	default:
+		return fingerprintONVIF(target, port, wp)
+	}
+}
+
+// fingerprintHTTP grabs whatever banner a plain HTTP/1.0 GET elicits, and pulls a
+// vendor/model guess out of the Server header if present.
+func fingerprintHTTP(target probeTarget, port string, wp workerParams) *ProbeResult {
+	conn, ok := fingerprintDial(target, port, wp.NetworkProtocol, wp)
+	if !ok {
+		return nil
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(wp.Timeout))
+	if _, err := conn.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		return nil
+	}
+
+	banner := readBounded(conn, 2048)
+	if banner == "" {
+		return nil
+	}
+
+	result := &ProbeResult{Host: target.IP.String(), Port: port, Banner: banner}
+	result.Vendor, result.Model = guessVendorModel(banner)
+	atomic.AddInt64(&wp.stats.hits, 1)
+	return result
+}
+
+// fingerprintTLS performs a TLS handshake and reports the DNS SANs of every
+// certificate the peer presents, which commonly leak a device's hostname/model on
+// embedded cameras.
+func fingerprintTLS(target probeTarget, port string, wp workerParams) *ProbeResult {
+	rawConn, ok := fingerprintDial(target, port, wp.NetworkProtocol, wp)
+	if !ok {
+		return nil
+	}
+	defer rawConn.Close()
+
+	tlsConn := tls.Client(rawConn, &tls.Config{InsecureSkipVerify: true})
+	_ = tlsConn.SetDeadline(time.Now().Add(wp.Timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil
+	}
+
+	var sans []string
+	for _, cert := range tlsConn.ConnectionState().PeerCertificates {
+		sans = append(sans, cert.DNSNames...)
+	}
+	if len(sans) == 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&wp.stats.hits, 1)
+	return &ProbeResult{Host: target.IP.String(), Port: port, TLSCertSANs: sans}
+}
+
+// fingerprintONVIF sends a unicast WS-Discovery Probe directly to target.IP:port
+// and reports the resulting ProbeMatch, if any, reusing the same SOAP envelope and
+// parser as MulticastDiscover.
+func fingerprintONVIF(target probeTarget, port string, wp workerParams) *ProbeResult {
+	conn, ok := fingerprintDial(target, port, udp, wp)
+	if !ok {
+		return nil
+	}
+	defer conn.Close()
+
+	msgID, err := newUUID()
+	if err != nil {
+		return nil
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(wp.Timeout))
+	if _, err := conn.Write([]byte(fmt.Sprintf(wsDiscoveryProbeTemplate, msgID))); err != nil {
+		return nil
+	}
+
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+
+	matches, err := parseProbeMatch(buf[:n])
+	if err != nil || len(matches) == 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&wp.stats.hits, 1)
+	result := matches[0]
+	result.Host = target.IP.String()
+	result.Port = port
+	return &result
+}
+
+// fingerprintDial dials target.IP:port over network, bound to target.Iface and
+// throttled by the same rate limiter and per-subnet AIMD slot that probe() uses,
+// so a fingerprint pass doesn't silently double a host's unthrottled connections
+// or leave via the wrong interface on a multi-homed host. The returned bool is
+// false if the slot/token couldn't be obtained or the dial failed.
+func fingerprintDial(target probeTarget, port string, network string, wp workerParams) (net.Conn, bool) {
+	if err := wp.rateLimiter.Take(wp.ctx); err != nil {
+		return nil, false
+	}
+
+	limiter := wp.limiters.get(target.IP)
+	if err := limiter.Acquire(wp.ctx); err != nil {
+		return nil, false
+	}
+
+	dialer := dialerForInterface(target.Iface, target.IP, network)
+	dialer.Timeout = wp.Timeout
+
+	atomic.AddInt64(&wp.stats.inFlight, 1)
+	conn, err := dialer.DialContext(wp.ctx, network, net.JoinHostPort(target.IP.String(), port))
+	atomic.AddInt64(&wp.stats.inFlight, -1)
+
+	isTimeout := err != nil && strings.Contains(err.Error(), "i/o timeout")
+	limiter.Release(isTimeout)
+	if isTimeout {
+		atomic.AddInt64(&wp.stats.timeouts, 1)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return conn, true
+}
+
+// readBounded performs a single Read of up to max bytes from conn and returns
+// whatever that call read, even if it also returned an error (including a timeout).
+func readBounded(conn net.Conn, max int) string {
+	buf := make([]byte, max)
+	n, _ := conn.Read(buf)
+	return string(buf[:n])
+}
+
+// guessVendorModel pulls a Vendor/Model guess out of an HTTP banner's Server
+// header, e.g. "Server: Hikvision/IP-Camera" -> ("Hikvision", "IP-Camera").
+func guessVendorModel(banner string) (vendor string, model string) {
+	scanner := bufio.NewScanner(strings.NewReader(banner))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(strings.ToLower(line), "server:") {
+			continue
+		}
+
+		value := strings.TrimSpace(line[len("Server:"):])
+		parts := strings.SplitN(value, "/", 2)
+		vendor = strings.TrimSpace(parts[0])
+		if len(parts) > 1 {
+			model = strings.TrimSpace(parts[1])
+		}
+		return vendor, model
+	}
+	return "", ""
+}
@@ -0,0 +1,337 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errNotUDPConn               = errors.New("not a udp connection")
+	errMulticastBindUnsupported = errors.New("binding the multicast outbound interface is not supported on this platform")
+)
+
+const (
+	wsDiscoveryPort          = "3702"
+	wsDiscoveryIPv4Multicast = "239.255.255.250"
+	wsDiscoveryIPv6Multicast = "ff02::c"
+
+	wsDiscoveryProbeTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope"
+            xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing"
+            xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"
+            xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+  <e:Header>
+    <w:MessageID>urn:uuid:%s</w:MessageID>
+    <w:To e:mustUnderstand="true">urn:schemas-xmlsoap-org:ws:2005:04:discovery</w:To>
+    <w:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</w:Action>
+  </e:Header>
+  <e:Body>
+    <d:Probe>
+      <d:Types>dn:NetworkVideoTransmitter</d:Types>
+    </d:Probe>
+  </e:Body>
+</e:Envelope>`
+)
+
+// probeMatchEnvelope is the minimal subset of a WS-Discovery ProbeMatch response
+// needed to identify a device and its service addresses.
+type probeMatchEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		ProbeMatches struct {
+			ProbeMatch []struct {
+				EndpointReference struct {
+					Address string `xml:"Address"`
+				} `xml:"EndpointReference"`
+				XAddrs string `xml:"XAddrs"`
+			} `xml:"ProbeMatch"`
+		} `xml:"ProbeMatches"`
+	} `xml:"Body"`
+}
+
+// MulticastDiscover sends a WS-Discovery Probe to the well-known multicast groups
+// on every usable interface and collects ProbeMatch responses for params.Timeout,
+// deduped by EndpointReference, converting each match into a DiscoveredDevice via
+// the same ConvertProbeResult pipeline AutoDiscover uses.
+//
+// This is a useful alternative (or complement) to sweeping a CIDR with AutoDiscover
+// on networks where sweeping is undesirable (large subnets, IDS alerts), and it
+// picks up devices whose ONVIF port isn't in the configured ScanPorts list.
+func MulticastDiscover(ctx context.Context, proto ProtocolSpecificDiscovery, params Params) []DiscoveredDevice {
+	ifaces, err := usableInterfaces(params.Subnets)
+	if err != nil {
+		params.Logger.Errorf("Unable to enumerate network interfaces for WS-Discovery: %s", err.Error())
+		return nil
+	}
+	if len(ifaces) == 0 {
+		params.Logger.Warnf("No usable network interfaces found for WS-Discovery multicast probing")
+		return nil
+	}
+
+	resultCh := make(chan []ProbeResult, len(ifaces))
+	var wg sync.WaitGroup
+	wg.Add(len(ifaces))
+	for _, iface := range ifaces {
+		go func(iface net.Interface) {
+			defer wg.Done()
+			results, err := probeInterface(ctx, iface, params)
+			if err != nil {
+				params.Logger.Debugf("WS-Discovery probe on interface %s failed: %s", iface.Name, err.Error())
+				return
+			}
+			if len(results) > 0 {
+				resultCh <- results
+			}
+		}(iface)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	seen := make(map[string]struct{})
+	devices := make([]DiscoveredDevice, 0)
+	for results := range resultCh {
+		for _, result := range results {
+			if result.EndpointRefAddress != "" {
+				if _, ok := seen[result.EndpointRefAddress]; ok {
+					continue
+				}
+				seen[result.EndpointRefAddress] = struct{}{}
+			}
+
+			dev, err := proto.ConvertProbeResult(result, params)
+			if err != nil {
+				params.Logger.Warnf("issue converting WS-Discovery probe match to discovered device: %s", err.Error())
+				continue
+			}
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// probeInterface sends a WS-Discovery Probe out iface to both the IPv4 and (when
+// available) the IPv6 WS-Discovery multicast groups, and collects ProbeMatch
+// responses until params.Timeout elapses.
+func probeInterface(ctx context.Context, iface net.Interface, params Params) ([]ProbeResult, error) {
+	conn, err := net.ListenPacket(udp, ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > timeout {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	msgID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	probe := []byte(fmt.Sprintf(wsDiscoveryProbeTemplate, msgID))
+
+	// Without this, every socket's IPv4 multicast traffic leaves via whatever
+	// interface the default route picks, so looping over ifaces wouldn't actually
+	// reach a secondary NIC/VLAN. The IPv6 leg doesn't need this: the zone is
+	// already embedded in the destination address string below.
+	if err := setMulticastInterface(conn, &iface); err != nil {
+		params.Logger.Debugf("unable to bind WS-Discovery multicast interface %s: %s", iface.Name, err.Error())
+	}
+
+	targets := []string{net.JoinHostPort(wsDiscoveryIPv4Multicast, wsDiscoveryPort)}
+	if hasIPv6(iface) {
+		targets = append(targets, net.JoinHostPort(wsDiscoveryIPv6Multicast+"%"+iface.Name, wsDiscoveryPort))
+	}
+
+	for _, target := range targets {
+		dst, err := net.ResolveUDPAddr(udp, target)
+		if err != nil {
+			params.Logger.Debugf("unable to resolve WS-Discovery target %q: %s", target, err.Error())
+			continue
+		}
+		if _, err := conn.WriteTo(probe, dst); err != nil {
+			params.Logger.Debugf("unable to send WS-Discovery probe via %s to %s: %s", iface.Name, target, err.Error())
+		}
+	}
+
+	var results []ProbeResult
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-ctx.Done():
+			return results, nil
+		default:
+		}
+
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// deadline reached or socket closed; return whatever we collected
+			return results, nil
+		}
+
+		matches, err := parseProbeMatch(buf[:n])
+		if err != nil {
+			params.Logger.Debugf("ignoring unparseable WS-Discovery response: %s", err.Error())
+			continue
+		}
+		results = append(results, matches...)
+	}
+}
+
+// parseProbeMatch decodes a WS-Discovery ProbeMatch SOAP envelope into a ProbeResult
+// per XAddr advertised by the device.
+func parseProbeMatch(data []byte) ([]ProbeResult, error) {
+	var envelope probeMatchEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+
+	var results []ProbeResult
+	for _, match := range envelope.Body.ProbeMatches.ProbeMatch {
+		endpoint := strings.TrimSpace(match.EndpointReference.Address)
+		xaddrs := strings.Fields(match.XAddrs)
+
+		for _, xaddr := range xaddrs {
+			u, err := url.Parse(xaddr)
+			if err != nil {
+				continue
+			}
+			results = append(results, ProbeResult{
+				Host:               u.Hostname(),
+				Port:               u.Port(),
+				XAddrs:             xaddrs,
+				EndpointRefAddress: endpoint,
+			})
+		}
+	}
+	return results, nil
+}
+
+// usableInterfaces returns the non-loopback, multicast-capable, up interfaces that
+// should be probed. When subnets is non-empty, only interfaces with an address in
+// one of the given CIDRs are returned.
+func usableInterfaces(subnets []string) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var nets []*net.IPNet
+	for _, cidr := range subnets {
+		if cidr == "" {
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+
+	usable := make([]net.Interface, 0, len(all))
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if len(nets) == 0 {
+			usable = append(usable, iface)
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		if interfaceInSubnets(addrs, nets) {
+			usable = append(usable, iface)
+		}
+	}
+	return usable, nil
+}
+
+func interfaceInSubnets(addrs []net.Addr, nets []*net.IPNet) bool {
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		for _, subnet := range nets {
+			if subnet.Contains(ipNet.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firstIPv4 returns the first IPv4 address configured on iface, as the 4-byte
+// form IP_MULTICAST_IF expects.
+func firstIPv4(iface *net.Interface) ([4]byte, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return [4]byte{}, err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if v4 := ipNet.IP.To4(); v4 != nil {
+			var b [4]byte
+			copy(b[:], v4)
+			return b, nil
+		}
+	}
+	return [4]byte{}, fmt.Errorf("interface %s has no IPv4 address", iface.Name)
+}
+
+func hasIPv6(iface net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newUUID generates a random (version 4) UUID for use as a WS-Discovery MessageID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,218 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// aimdWindowSize is how many recent outcomes a subnetLimiter looks at before
+	// deciding whether to grow or shrink its concurrency limit.
+	aimdWindowSize = 20
+	// aimdTimeoutBurstPct is the fraction of timeouts in a window that triggers a
+	// multiplicative decrease of the subnet's concurrency limit.
+	aimdTimeoutBurstPct = 0.5
+	aimdMinConcurrency  = 1
+)
+
+// ProbeStats is a point-in-time snapshot of an AutoDiscover run's probe counters,
+// taken when that run returns.
+type ProbeStats struct {
+	InFlight int64
+	Timeouts int64
+	Hits     int64
+}
+
+// discoveryStats holds the live counters for a single AutoDiscover invocation.
+// It is scoped to that one run (created fresh in AutoDiscover and threaded
+// through workerParams) rather than kept as package-level globals, so that two
+// AutoDiscover calls in flight at once - e.g. a manual "Discover" trigger
+// landing while a periodic auto-discovery run is still in progress - don't
+// stomp on each other's counters.
+type discoveryStats struct {
+	inFlight int64
+	timeouts int64
+	hits     int64
+}
+
+func newDiscoveryStats() *discoveryStats {
+	return &discoveryStats{}
+}
+
+// Snapshot returns a point-in-time copy of s's counters.
+func (s *discoveryStats) Snapshot() ProbeStats {
+	return ProbeStats{
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Timeouts: atomic.LoadInt64(&s.timeouts),
+		Hits:     atomic.LoadInt64(&s.hits),
+	}
+}
+
+// tokenBucket is a simple global rate limiter for outbound probe dials. A nil
+// *tokenBucket is treated as unbounded, so callers don't need to nil-check before
+// calling Take.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64 // tokens added per second
+	last     time.Time
+}
+
+// newTokenBucket returns a tokenBucket allowing perSecond dials/sec, or nil
+// (unbounded) if perSecond is not positive.
+func newTokenBucket(perSecond int) *tokenBucket {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		tokens:   float64(perSecond),
+		capacity: float64(perSecond),
+		rate:     float64(perSecond),
+		last:     time.Now(),
+	}
+}
+
+// Take blocks until a token is available or ctx is cancelled.
+func (b *tokenBucket) Take(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// subnetLimiter adaptively bounds how many in-flight probes are allowed against a
+// single subnet at once: additive-increase of the limit on clean responses,
+// multiplicative-decrease on a burst of "i/o timeout"s, so a congested or
+// tightly-monitored network backs off instead of looking like a port scan.
+type subnetLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	inFlight int
+	window   []bool // recent outcomes; true = timeout
+}
+
+func newSubnetLimiter(max int) *subnetLimiter {
+	if max < aimdMinConcurrency {
+		max = aimdMinConcurrency
+	}
+	l := &subnetLimiter{limit: max, max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Acquire blocks until a slot is free or ctx is cancelled.
+func (l *subnetLimiter) Acquire(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.limit {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.cond.Wait()
+	}
+	l.inFlight++
+	return nil
+}
+
+// Release returns a slot to the pool, and feeds the outcome of the probe that
+// held it into the AIMD decision for this subnet.
+func (l *subnetLimiter) Release(isTimeout bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	l.window = append(l.window, isTimeout)
+	if len(l.window) >= aimdWindowSize {
+		timeouts := 0
+		for _, t := range l.window {
+			if t {
+				timeouts++
+			}
+		}
+		switch {
+		case float64(timeouts)/float64(len(l.window)) >= aimdTimeoutBurstPct:
+			l.limit = int(math.Max(aimdMinConcurrency, float64(l.limit)/2))
+		case timeouts == 0 && l.limit < l.max:
+			l.limit++
+		}
+		l.window = l.window[:0]
+	}
+
+	l.cond.Broadcast()
+}
+
+// limiterRegistry hands out a subnetLimiter per subnet prefix (/24 for IPv4, /64
+// for IPv6), creating one lazily the first time that prefix is probed.
+type limiterRegistry struct {
+	mu       sync.Mutex
+	limiters map[string]*subnetLimiter
+	max      int
+}
+
+func newLimiterRegistry(max int) *limiterRegistry {
+	return &limiterRegistry{limiters: make(map[string]*subnetLimiter), max: max}
+}
+
+func (r *limiterRegistry) get(ip net.IP) *subnetLimiter {
+	key := subnetKey(ip)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[key]
+	if !ok {
+		l = newSubnetLimiter(r.max)
+		r.limiters[key] = l
+	}
+	return l
+}
+
+// subnetKey groups an IP into the /24 (IPv4) or /64 (IPv6) it belongs to, which
+// is the granularity AIMD backoff decisions are made at.
+func subnetKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"context"
+	"testing"
+)
+
+// runWindow acquires/releases l aimdWindowSize times, reporting isTimeout for
+// every release, so the AIMD decision at the end of the window fires exactly
+// once.
+func runWindow(t *testing.T, l *subnetLimiter, isTimeout bool) {
+	t.Helper()
+	for i := 0; i < aimdWindowSize; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		l.Release(isTimeout)
+	}
+}
+
+func TestSubnetLimiterAIMD(t *testing.T) {
+	t.Run("clean window grows the limit up to max", func(t *testing.T) {
+		l := newSubnetLimiter(8)
+		l.limit = 2
+
+		runWindow(t, l, false)
+
+		if l.limit != 3 {
+			t.Fatalf("expected limit to grow by 1 after a clean window, got %d", l.limit)
+		}
+	})
+
+	t.Run("clean window does not grow past max", func(t *testing.T) {
+		l := newSubnetLimiter(4)
+
+		runWindow(t, l, false)
+
+		if l.limit != 4 {
+			t.Fatalf("expected limit to stay at max 4, got %d", l.limit)
+		}
+	})
+
+	t.Run("timeout burst halves the limit", func(t *testing.T) {
+		l := newSubnetLimiter(8)
+
+		runWindow(t, l, true)
+
+		if l.limit != 4 {
+			t.Fatalf("expected limit to halve to 4 after an all-timeout window, got %d", l.limit)
+		}
+	})
+
+	t.Run("timeout burst never shrinks below the minimum", func(t *testing.T) {
+		l := newSubnetLimiter(2)
+		l.limit = 1
+
+		runWindow(t, l, true)
+
+		if l.limit != aimdMinConcurrency {
+			t.Fatalf("expected limit to stay at the minimum %d, got %d", aimdMinConcurrency, l.limit)
+		}
+	})
+
+	t.Run("Acquire unblocks when a slot is released", func(t *testing.T) {
+		l := newSubnetLimiter(1)
+
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("first Acquire: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- l.Acquire(context.Background())
+		}()
+
+		l.Release(false)
+
+		if err := <-done; err != nil {
+			t.Fatalf("second Acquire: %v", err)
+		}
+	})
+
+	t.Run("Acquire respects context cancellation", func(t *testing.T) {
+		l := newSubnetLimiter(1)
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("first Acquire: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- l.Acquire(ctx)
+		}()
+
+		cancel()
+
+		if err := <-done; err == nil {
+			t.Fatal("expected Acquire to return an error once its context was cancelled")
+		}
+	})
+}
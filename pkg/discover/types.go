@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2022 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package discover
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v3/clients/logger"
+)
+
+// Params holds all the configuration needed by AutoDiscover to probe a set of
+// subnets looking for devices belonging to a specific protocol.
+type Params struct {
+	Logger logger.LoggingClient
+
+	// Subnets is the list of CIDRs (IPv4 or IPv6) to probe.
+	Subnets []string
+
+	AsyncLimit         int
+	Timeout            time.Duration
+	ScanPorts          []string
+	MaxTimeoutsPerHost int
+	NetworkProtocol    string
+
+	// MaxIPv6SweepHosts caps how many addresses of an in-range IPv6 prefix
+	// AutoDiscover will enumerate during a sweep. Zero means use the package
+	// default (see defaultMaxIPv6SweepHosts).
+	MaxIPv6SweepHosts int
+
+	// MaxProbesPerSecond throttles outbound dials globally across the whole
+	// AutoDiscover run via a token bucket. Zero means unbounded. There is no
+	// separate per-subnet rate knob: per-subnet backoff is handled instead by
+	// the AIMD concurrency limiter (see limiterRegistry), which adapts each
+	// subnet's in-flight limit to the timeouts it's actually seeing rather than
+	// a fixed dials/sec rate.
+	MaxProbesPerSecond int
+
+	// SubnetInterfaces optionally names, for a given CIDR in Subnets, the network
+	// interface probes against that subnet should be bound to. This matters on
+	// multi-homed hosts (e.g. an EdgeX gateway with one NIC on the corporate LAN
+	// and one on the camera VLAN) where the kernel's default route may not be the
+	// interface that can actually reach the configured subnet.
+	SubnetInterfaces map[string]string
+
+	// Fingerprint enables a best-effort identification pass (HTTP banner, TLS cert
+	// SANs, or a unicast WS-Discovery probe, depending on the port) after a
+	// successful dial, populating ProbeResult.Banner/TLSCertSANs/Vendor/Model.
+	Fingerprint bool
+}
+
+// ProbeResult represents a single successful probe of a host/port combination.
+type ProbeResult struct {
+	Host string
+	Port string
+
+	// XAddrs and EndpointRefAddress are populated when the result came from a
+	// WS-Discovery ProbeMatch (see MulticastDiscover) rather than a TCP sweep.
+	XAddrs             []string
+	EndpointRefAddress string
+
+	// Banner, TLSCertSANs, Vendor, and Model are populated by the optional
+	// fingerprinting stage (see Params.Fingerprint).
+	Banner      string
+	TLSCertSANs []string
+	Vendor      string
+	Model       string
+}
+
+// DiscoveredDevice is the protocol-agnostic result of converting a ProbeResult
+// into something the calling driver can register as an EdgeX device.
+type DiscoveredDevice struct {
+	Name    string
+	Address string
+	Port    string
+}
+
+// ProtocolSpecificDiscovery is implemented by callers of AutoDiscover to customize
+// which ports get probed, what happens once a connection is established, and how
+// a successful probe gets turned into a DiscoveredDevice.
+type ProtocolSpecificDiscovery interface {
+	// ProbeFilter takes in a host and a list of ports to be scanned, and filters it
+	// down to a list of ports to actually scan for that particular host
+	ProbeFilter(host string, ports []string) []string
+	// OnConnectionDialed handles a successful connection to a host:port, returning
+	// any ProbeResults discovered as a result
+	OnConnectionDialed(host string, port string, conn net.Conn, params Params) ([]ProbeResult, error)
+	// ConvertProbeResult converts a raw ProbeResult into a DiscoveredDevice
+	ConvertProbeResult(probeResult ProbeResult, params Params) (DiscoveredDevice, error)
+}
+
+// workerParams bundles the shared state needed by every ipWorker goroutine.
+type workerParams struct {
+	Params
+	ipCh        chan probeTarget
+	resultCh    chan []ProbeResult
+	ctx         context.Context
+	proto       ProtocolSpecificDiscovery
+	rateLimiter *tokenBucket
+	limiters    *limiterRegistry
+	stats       *discoveryStats
+}
+
+// computeNetSz returns the total number of addresses in an IPv4 subnet with the
+// given mask size, including the network and broadcast addresses.
+func computeNetSz(maskSz int) uint64 {
+	return uint64(1) << uint(32-maskSz)
+}